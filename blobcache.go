@@ -0,0 +1,142 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// BlobCache stores arbitrary string blobs (e.g. base64 thumbnail data
+// URIs) under the same PREVIEW_CACHE backend selection as Cache.
+type BlobCache interface {
+	// Get returns the cached value for key, or ok=false if there's no
+	// (unexpired) entry.
+	Get(key string) (value string, ok bool, err error)
+	// Set stores value for key, expiring it after ttl.
+	Set(key string, value string, ttl time.Duration) error
+}
+
+var (
+	blobCacheOnce sync.Once
+	appBlobCache  BlobCache
+)
+
+// getBlobCache returns the process-wide BlobCache, built once on first
+// use. It rides on whatever backend getCache() selected: the same Redis
+// pool if HTMLMeta is cached in Redis, or an in-process LRU if
+// PREVIEW_CACHE=memory or Redis was unreachable.
+func getBlobCache() BlobCache {
+	blobCacheOnce.Do(func() {
+		if rc, ok := getCache().(*redisCache); ok {
+			appBlobCache = &redisBlobCache{pool: rc.pool}
+		} else {
+			appBlobCache = newMemoryBlobCache(defaultMemoryCacheCapacity)
+		}
+	})
+	return appBlobCache
+}
+
+// redisBlobCacheKeyPrefix namespaces blobs separately from the HTMLMeta
+// cache entries in the shared Redis instance.
+const redisBlobCacheKeyPrefix = "url_preview_img:"
+
+// redisBlobCache is the BlobCache backed by the Redis pool shared with
+// redisCache.
+type redisBlobCache struct {
+	pool *redis.Pool
+}
+
+func (r *redisBlobCache) Get(key string) (string, bool, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	s, err := redis.String(conn.Do("GET", redisBlobCacheKeyPrefix+key))
+	if err == redis.ErrNil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return s, true, nil
+}
+
+func (r *redisBlobCache) Set(key, value string, ttl time.Duration) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("SETEX", redisBlobCacheKeyPrefix+key, int(ttl.Seconds()), value)
+	return err
+}
+
+// memoryBlobCache is the in-process BlobCache used when PREVIEW_CACHE=memory,
+// or as a fallback when Redis is unreachable. Like memoryCache, it's a
+// size-bounded LRU with per-entry TTL eviction.
+type memoryBlobCache struct {
+	mu       sync.Mutex
+	ll       *list.List
+	items    sync.Map // key -> *list.Element
+	capacity int
+}
+
+type memoryBlobItem struct {
+	key       string
+	value     string
+	expiresAt time.Time
+}
+
+func newMemoryBlobCache(capacity int) *memoryBlobCache {
+	return &memoryBlobCache{ll: list.New(), capacity: capacity}
+}
+
+func (m *memoryBlobCache) Get(key string) (string, bool, error) {
+	v, ok := m.items.Load(key)
+	if !ok {
+		return "", false, nil
+	}
+	elem := v.(*list.Element)
+	item := elem.Value.(*memoryBlobItem)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if time.Now().After(item.expiresAt) {
+		m.removeLocked(elem)
+		return "", false, nil
+	}
+	m.ll.MoveToFront(elem)
+	return item.value, true, nil
+}
+
+func (m *memoryBlobCache) Set(key, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expiresAt := time.Now().Add(ttl)
+	if v, ok := m.items.Load(key); ok {
+		elem := v.(*list.Element)
+		elem.Value.(*memoryBlobItem).value = value
+		elem.Value.(*memoryBlobItem).expiresAt = expiresAt
+		m.ll.MoveToFront(elem)
+		return nil
+	}
+
+	elem := m.ll.PushFront(&memoryBlobItem{key: key, value: value, expiresAt: expiresAt})
+	m.items.Store(key, elem)
+
+	if m.capacity > 0 && m.ll.Len() > m.capacity {
+		if oldest := m.ll.Back(); oldest != nil {
+			m.removeLocked(oldest)
+		}
+	}
+	return nil
+}
+
+// removeLocked removes elem from both the list and the map. Callers must
+// hold m.mu.
+func (m *memoryBlobCache) removeLocked(elem *list.Element) {
+	item := elem.Value.(*memoryBlobItem)
+	m.items.Delete(item.key)
+	m.ll.Remove(elem)
+}
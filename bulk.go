@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultBulkConcurrency is the fallback worker pool size for /bulk when
+// PREVIEW_BULK_CONCURRENCY isn't set or isn't a valid positive integer.
+const defaultBulkConcurrency = 8
+
+// bulkFetchTimeout bounds how long a single URL in a /bulk batch may take,
+// so one slow origin can't stall the whole request.
+const bulkFetchTimeout = 10 * time.Second
+
+// BulkRequest is the expected POST /bulk body.
+type BulkRequest struct {
+	URLs []string `json:"urls"`
+}
+
+// BulkURLPreview handles POST /bulk, fetching previews for a batch of URLs
+// concurrently and returning them in the order they were requested.
+func BulkURLPreview(w http.ResponseWriter, r *http.Request) {
+	var req BulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Respond(w, Message(nil, "Request body must be JSON of the form {\"urls\": [...]}.", false, false))
+		return
+	}
+
+	cache := getCache()
+	results := make([]map[string]interface{}, len(req.URLs))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < bulkConcurrency(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				results[idx] = bulkFetchOne(r.Context(), cache, req.URLs[idx])
+			}
+		}()
+	}
+	for i := range req.URLs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	Respond(w, Message(results, "", true, false))
+}
+
+// bulkFetchOne resolves a single URL for a /bulk batch, returning a result
+// in the same {data, status, message} shape Message produces.
+func bulkFetchOne(ctx context.Context, cache Cache, rawURL string) map[string]interface{} {
+	url, err := HandleURL(rawURL)
+	if err != nil {
+		return bulkMessage(rawURL, nil, url, false, false)
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, bulkFetchTimeout)
+	defer cancel()
+
+	meta, fromCache, err := fetchMeta(fetchCtx, cache, url)
+	if err != nil {
+		return bulkMessage(rawURL, nil, err.Error(), false, false)
+	}
+
+	return bulkMessage(rawURL, meta, "", true, fromCache)
+}
+
+func bulkMessage(url string, data interface{}, message string, status, fromCache bool) map[string]interface{} {
+	m := Message(data, message, status, fromCache)
+	m["url"] = url
+	return m
+}
+
+// bulkConcurrency reads PREVIEW_BULK_CONCURRENCY, falling back to
+// defaultBulkConcurrency if it's unset or not a valid positive integer.
+func bulkConcurrency() int {
+	return envInt("PREVIEW_BULK_CONCURRENCY", defaultBulkConcurrency)
+}
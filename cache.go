@@ -0,0 +1,225 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"github.com/gomodule/redigo/redis"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultCacheTTL is how long a successful fetch is cached when the
+// origin doesn't send a Cache-Control max-age of its own.
+const defaultCacheTTL = time.Hour
+
+// negativeCacheTTL is how long a failed fetch is cached, so a broken URL
+// can't be hammered on every request.
+const negativeCacheTTL = 60 * time.Second
+
+// revalidationWindow extends how long a stale entry is kept in the
+// store past its freshness TTL, so it's still around to be revalidated
+// with a conditional GET (ETag/Last-Modified) instead of being evicted
+// and forcing a full, non-conditional re-fetch.
+const revalidationWindow = 24 * time.Hour
+
+// defaultMemoryCacheCapacity bounds the in-process cache when
+// PREVIEW_CACHE=memory, evicting least-recently-used entries past it.
+const defaultMemoryCacheCapacity = 10000
+
+// Cache is the storage backend for fetched HTMLMeta. Implementations must
+// be safe for concurrent use.
+type Cache interface {
+	// Get returns the cached entry for url, or ok=false if there's no
+	// (unexpired) entry.
+	Get(url string) (entry cacheEntry, ok bool, err error)
+	// Set stores entry for url, expiring it after ttl.
+	Set(url string, entry cacheEntry, ttl time.Duration) error
+	// SetNegative records that fetching url failed, so repeated requests
+	// for it don't keep hitting the network until ttl has passed.
+	SetNegative(url string, fetchErr error, ttl time.Duration) error
+}
+
+var (
+	cacheOnce sync.Once
+	appCache  Cache
+)
+
+// getCache returns the process-wide Cache, selected by PREVIEW_CACHE
+// ("redis", the default, or "memory") and built once on first use.
+func getCache() Cache {
+	cacheOnce.Do(func() {
+		appCache = newCache()
+	})
+	return appCache
+}
+
+func newCache() Cache {
+	if os.Getenv("PREVIEW_CACHE") == "memory" {
+		return newMemoryCache(defaultMemoryCacheCapacity)
+	}
+
+	cache, err := newRedisCache()
+	if err != nil {
+		log.Printf("urlPreviewGo: redis unavailable (%v), falling back to in-memory cache", err)
+		return newMemoryCache(defaultMemoryCacheCapacity)
+	}
+	return cache
+}
+
+// redisCacheKeyPrefix namespaces our keys in a shared Redis instance.
+const redisCacheKeyPrefix = "url_preview:"
+
+// redisCache is the Cache backed by the Redis pool.
+type redisCache struct {
+	pool *redis.Pool
+}
+
+// newRedisCache builds a redisCache, pinging Redis once up front so
+// callers can fall back to the in-memory cache instead of discovering
+// the problem on the first real request.
+func newRedisCache() (*redisCache, error) {
+	pool := newPool()
+	conn := pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		return nil, err
+	}
+	return &redisCache{pool: pool}, nil
+}
+
+func newPool() *redis.Pool {
+	return &redis.Pool{
+		// Maximum number of idle connections in the pool.
+		MaxIdle: 80,
+		// max number of connections
+		MaxActive: 12000,
+		// Dial is an application supplied function for creating and
+		// configuring a connection.
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", ":6379")
+		},
+	}
+}
+
+func (r *redisCache) Get(url string) (cacheEntry, bool, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	s, err := redis.String(conn.Do("GET", redisCacheKeyPrefix+url))
+	if err == redis.ErrNil {
+		return cacheEntry{}, false, nil
+	}
+	if err != nil {
+		return cacheEntry{}, false, err
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal([]byte(s), &entry); err != nil {
+		return cacheEntry{}, false, err
+	}
+	return entry, true, nil
+}
+
+func (r *redisCache) Set(url string, entry cacheEntry, ttl time.Duration) error {
+	return r.setEntry(url, entry, ttl)
+}
+
+func (r *redisCache) SetNegative(url string, fetchErr error, ttl time.Duration) error {
+	return r.setEntry(url, cacheEntry{Negative: true, Err: fetchErr.Error()}, ttl)
+}
+
+func (r *redisCache) setEntry(url string, entry cacheEntry, ttl time.Duration) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("SETEX", redisCacheKeyPrefix+url, int(ttl.Seconds()), data)
+	return err
+}
+
+// memoryCache is the in-process Cache used when PREVIEW_CACHE=memory, or
+// as a fallback when Redis is unreachable. It's a size-bounded LRU with
+// per-entry TTL eviction, backed by a sync.Map for reads plus a
+// mutex-guarded list.List that tracks recency for eviction.
+type memoryCache struct {
+	mu       sync.Mutex
+	ll       *list.List
+	items    sync.Map // url -> *list.Element
+	capacity int
+}
+
+type memoryCacheItem struct {
+	key       string
+	entry     cacheEntry
+	expiresAt time.Time
+}
+
+func newMemoryCache(capacity int) *memoryCache {
+	return &memoryCache{ll: list.New(), capacity: capacity}
+}
+
+func (m *memoryCache) Get(url string) (cacheEntry, bool, error) {
+	v, ok := m.items.Load(url)
+	if !ok {
+		return cacheEntry{}, false, nil
+	}
+	elem := v.(*list.Element)
+	item := elem.Value.(*memoryCacheItem)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if time.Now().After(item.expiresAt) {
+		m.removeLocked(elem)
+		return cacheEntry{}, false, nil
+	}
+	m.ll.MoveToFront(elem)
+	return item.entry, true, nil
+}
+
+func (m *memoryCache) Set(url string, entry cacheEntry, ttl time.Duration) error {
+	m.store(url, entry, ttl)
+	return nil
+}
+
+func (m *memoryCache) SetNegative(url string, fetchErr error, ttl time.Duration) error {
+	m.store(url, cacheEntry{Negative: true, Err: fetchErr.Error()}, ttl)
+	return nil
+}
+
+func (m *memoryCache) store(url string, entry cacheEntry, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expiresAt := time.Now().Add(ttl)
+	if v, ok := m.items.Load(url); ok {
+		elem := v.(*list.Element)
+		elem.Value.(*memoryCacheItem).entry = entry
+		elem.Value.(*memoryCacheItem).expiresAt = expiresAt
+		m.ll.MoveToFront(elem)
+		return
+	}
+
+	elem := m.ll.PushFront(&memoryCacheItem{key: url, entry: entry, expiresAt: expiresAt})
+	m.items.Store(url, elem)
+
+	if m.capacity > 0 && m.ll.Len() > m.capacity {
+		if oldest := m.ll.Back(); oldest != nil {
+			m.removeLocked(oldest)
+		}
+	}
+}
+
+// removeLocked removes elem from both the list and the map. Callers must
+// hold m.mu.
+func (m *memoryCache) removeLocked(elem *list.Element) {
+	item := elem.Value.(*memoryCacheItem)
+	m.items.Delete(item.key)
+	m.ll.Remove(elem)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// userAgent is sent on every outbound fetch so origins can identify us.
+const userAgent = "urlPreviewGo/1.0 (+https://github.com/sbabashahi/urlPreviewGo)"
+
+// maxRedirects bounds how many hops URLPreview will follow before giving up.
+const maxRedirects = 5
+
+// maxBodyBytes caps how much of a response body we'll read, so a hostile
+// origin can't exhaust memory by streaming an unbounded response.
+const maxBodyBytes = 2 << 20 // 2MB
+
+// fetchTimeout bounds a single attempt at fetching a page.
+const fetchTimeout = 10 * time.Second
+
+// maxFetchRetries is how many times we'll retry a transport error or 5xx
+// response before giving up, with exponential backoff between attempts.
+const maxFetchRetries = 3
+
+// httpClient is shared across requests: it carries our redirect policy
+// (hop limit, scheme-downgrade and private-IP rejection) and dials
+// through a resolver that re-validates every IP it connects to, so a
+// redirect or DNS rebind can't be used to reach internal services.
+var httpClient = &http.Client{
+	Timeout: fetchTimeout,
+	Transport: &http.Transport{
+		DialContext: dialPublicOnly,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		if via[len(via)-1].URL.Scheme == "https" && req.URL.Scheme != "https" {
+			return errors.New("refusing to follow a redirect from https to a non-https URL")
+		}
+		return nil
+	},
+}
+
+// dialPublicOnly resolves addr itself and refuses to connect to private,
+// loopback, or link-local addresses, closing the DNS-rebinding gap left by
+// checking a hostname once and dialing it later.
+func dialPublicOnly(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	if ip := net.ParseIP(host); ip != nil {
+		if isPrivateIP(ip) {
+			return nil, fmt.Errorf("refusing to connect to private address %s", ip)
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, ipAddr := range ips {
+		if isPrivateIP(ipAddr.IP) {
+			lastErr = fmt.Errorf("refusing to connect to private address %s", ipAddr.IP)
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ipAddr.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no route to host %s", host)
+	}
+	return nil, lastErr
+}
+
+// isPrivateIP reports whether ip is loopback, link-local, unspecified, or
+// within an RFC1918/RFC4193 private range.
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return true
+	}
+	for _, cidr := range []string{
+		"10.0.0.0/8",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"fc00::/7",
+	} {
+		_, block, err := net.ParseCIDR(cidr)
+		if err == nil && block.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchURL performs a GET against rawURL, retrying transport errors and
+// 5xx responses with exponential backoff. etag and lastModified, if set,
+// are sent as conditional-GET headers so the origin can reply 304.
+func fetchURL(ctx context.Context, rawURL, etag, lastModified string) (*http.Response, error) {
+	backoff := 250 * time.Millisecond
+	var lastErr error
+
+	for attempt := 0; attempt <= maxFetchRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			backoff *= 2
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", userAgent)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("upstream returned %s", resp.Status)
+			continue
+		}
+		return resp, nil
+	}
+
+	return nil, lastErr
+}
+
+// maxAgeSeconds extracts the max-age directive from a Cache-Control
+// header, returning 0 if it's absent, unparsable, or not positive. A
+// max-age=0 therefore reads the same as no max-age at all.
+func maxAgeSeconds(h http.Header) int64 {
+	cc := h.Get("Cache-Control")
+	for _, directive := range strings.Split(cc, ",") {
+		directive = strings.TrimSpace(directive)
+		if v, ok := strings.CutPrefix(directive, "max-age="); ok {
+			if secs, err := strconv.ParseInt(v, 10, 64); err == nil && secs > 0 {
+				return secs
+			}
+		}
+	}
+	return 0
+}
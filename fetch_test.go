@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestIsPrivateIP(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want bool
+	}{
+		{"loopback v4", "127.0.0.1", true},
+		{"loopback v6", "::1", true},
+		{"link-local v4 (cloud metadata)", "169.254.169.254", true},
+		{"rfc1918 10/8", "10.0.0.1", true},
+		{"rfc1918 172.16/12", "172.16.5.4", true},
+		{"rfc1918 192.168/16", "192.168.1.1", true},
+		{"unique-local v6", "fc00::1", true},
+		{"unspecified v4", "0.0.0.0", true},
+		{"public v4", "93.184.216.34", false},
+		{"public v6", "2606:4700:4700::1111", false},
+		{"just outside 172.16/12", "172.32.0.1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip := net.ParseIP(tt.ip)
+			if ip == nil {
+				t.Fatalf("net.ParseIP(%q) returned nil", tt.ip)
+			}
+			if got := isPrivateIP(ip); got != tt.want {
+				t.Errorf("isPrivateIP(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialPublicOnlyRejectsPrivateAddresses(t *testing.T) {
+	tests := []string{
+		"127.0.0.1:80",
+		"169.254.169.254:80", // cloud metadata endpoint
+		"10.0.0.1:6379",
+		"192.168.1.1:80",
+		"[::1]:80",
+	}
+
+	for _, addr := range tests {
+		t.Run(addr, func(t *testing.T) {
+			_, err := dialPublicOnly(context.Background(), "tcp", addr)
+			if err == nil {
+				t.Fatalf("dialPublicOnly(%q) = nil error, want refusal", addr)
+			}
+			if !strings.Contains(err.Error(), "refusing to connect") {
+				t.Errorf("dialPublicOnly(%q) error = %q, want it to mention refusing to connect", addr, err.Error())
+			}
+		})
+	}
+}
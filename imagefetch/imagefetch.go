@@ -0,0 +1,131 @@
+// Package imagefetch downloads and thumbnails the favicon and preview
+// images referenced by a page's meta tags.
+package imagefetch
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	parse "net/url"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// MaxBytes caps how much of an image response we'll read, so a hostile
+// origin can't exhaust memory with an oversized or unbounded body.
+const MaxBytes = 5 << 20 // 5MB
+
+// DefaultMaxDimension is used by Resize when maxDimension is <= 0.
+const DefaultMaxDimension = 256
+
+// Image is a downloaded image, optionally resized, ready to cache or
+// embed as a data URI.
+type Image struct {
+	Bytes       []byte
+	ContentType string
+}
+
+// Resolve resolves ref (an href or content attribute, often relative)
+// against pageURL.
+func Resolve(pageURL, ref string) (string, error) {
+	base, err := parse.Parse(pageURL)
+	if err != nil {
+		return "", err
+	}
+	rel, err := parse.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(rel).String(), nil
+}
+
+// FaviconFallback returns <scheme>://<host>/favicon.ico for pageURL, used
+// when no icon URL was found for the page.
+func FaviconFallback(pageURL string) (string, error) {
+	u, err := parse.Parse(pageURL)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s://%s/favicon.ico", u.Scheme, u.Host), nil
+}
+
+// Fetch downloads rawURL via client, verifying via http.DetectContentType
+// that it's actually an image and enforcing MaxBytes.
+func Fetch(client *http.Client, rawURL string) (*Image, error) {
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("imagefetch: %s returned %s", rawURL, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxBytes {
+		return nil, fmt.Errorf("imagefetch: %s exceeds %d byte limit", rawURL, MaxBytes)
+	}
+
+	contentType := http.DetectContentType(body)
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("imagefetch: %s is not an image (got %s)", rawURL, contentType)
+	}
+
+	return &Image{Bytes: body, ContentType: contentType}, nil
+}
+
+// Resize decodes img and, if either dimension exceeds maxDimension,
+// scales it down preserving aspect ratio. Formats we can't decode (most
+// notably .ico favicons) are returned unchanged, since we can't safely
+// re-encode something we didn't decode.
+func Resize(img *Image, maxDimension int) (*Image, error) {
+	if maxDimension <= 0 {
+		maxDimension = DefaultMaxDimension
+	}
+
+	decoded, format, err := image.Decode(bytes.NewReader(img.Bytes))
+	if err != nil {
+		return img, nil
+	}
+
+	bounds := decoded.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= maxDimension && h <= maxDimension {
+		return img, nil
+	}
+
+	scale := float64(maxDimension) / float64(w)
+	if hScale := float64(maxDimension) / float64(h); hScale < scale {
+		scale = hScale
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, int(float64(w)*scale), int(float64(h)*scale)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), decoded, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if format == "png" {
+		if err := png.Encode(&buf, dst); err != nil {
+			return nil, err
+		}
+		return &Image{Bytes: buf.Bytes(), ContentType: "image/png"}, nil
+	}
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return &Image{Bytes: buf.Bytes(), ContentType: "image/jpeg"}, nil
+}
+
+// DataURI encodes img as a base64 data: URI suitable for inlining in JSON.
+func DataURI(img *Image) string {
+	return fmt.Sprintf("data:%s;base64,%s", img.ContentType, base64.StdEncoding.EncodeToString(img.Bytes))
+}
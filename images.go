@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/sbabashahi/urlPreviewGo/imagefetch"
+)
+
+// thumbnailMaxDimension bounds the width/height of embedded thumbnails.
+const thumbnailMaxDimension = 256
+
+// thumbnailCacheTTL is how long an embedded thumbnail's data URI stays
+// cached before it's re-fetched and re-resized.
+const thumbnailCacheTTL = defaultCacheTTL
+
+// embedThumbnails fills IconData/ImageData on meta with base64 data URIs,
+// resolving relative icon/image URLs against pageURL and falling back to
+// <domain>/favicon.ico when no icon was found on the page. Thumbnails are
+// cached through the same pluggable BlobCache backend as HTMLMeta, so
+// PREVIEW_CACHE=memory works here too instead of always reaching for Redis.
+func embedThumbnails(pageURL string, meta *HTMLMeta) {
+	cache := getBlobCache()
+
+	if icon, err := thumbnail(cache, pageURL, meta.Icon, true); err == nil {
+		meta.IconData = icon
+	}
+	if image, err := thumbnail(cache, pageURL, meta.Image, false); err == nil {
+		meta.ImageData = image
+	}
+}
+
+// thumbnail resolves ref against pageURL (or falls back to favicon.ico),
+// returning a cached base64 data URI for the downloaded, resized image.
+func thumbnail(cache BlobCache, pageURL, ref string, isFavicon bool) (string, error) {
+	target := ref
+	var err error
+	if target != "" {
+		target, err = imagefetch.Resolve(pageURL, target)
+		if err != nil {
+			return "", err
+		}
+	} else if isFavicon {
+		target, err = imagefetch.FaviconFallback(pageURL)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		return "", nil
+	}
+
+	if cached, ok, err := cache.Get(target); err == nil && ok {
+		return cached, nil
+	}
+
+	img, err := imagefetch.Fetch(httpClient, target)
+	if err != nil {
+		return "", err
+	}
+	img, err = imagefetch.Resize(img, thumbnailMaxDimension)
+	if err != nil {
+		return "", err
+	}
+
+	dataURI := imagefetch.DataURI(img)
+	cache.Set(target, dataURI, thumbnailCacheTTL)
+	return dataURI, nil
+}
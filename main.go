@@ -1,11 +1,11 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
-	"github.com/gomodule/redigo/redis"
 	"golang.org/x/net/html"
 	"io"
 	"net/http"
@@ -14,11 +14,22 @@ import (
 	"time"
 )
 
+// oEmbedTimeout bounds how long we'll wait on an oEmbed endpoint before
+// giving up and returning whatever meta we already extracted.
+const oEmbedTimeout = 5 * time.Second
+
 func main() {
 	router := mux.NewRouter()
 	router.HandleFunc("/", URLPreview).Methods("GET")
+	router.HandleFunc("/bulk", BulkURLPreview).Methods("POST")
 	router.NotFoundHandler = http.HandlerFunc(Custom404Handler)
-	err := http.ListenAndServe(":8000", router)
+
+	var handler http.Handler = router
+	handler = authMiddleware(handler)
+	handler = rateLimitMiddleware(handler)
+	handler = loggingMiddleware(handler)
+
+	err := http.ListenAndServe(":8000", handler)
 	if err != nil {
 		fmt.Print(err)
 	}
@@ -26,7 +37,7 @@ func main() {
 
 // Custom404Handler handle 404 response
 func Custom404Handler(w http.ResponseWriter, r *http.Request) {
-	Respond(w, Message(nil, fmt.Sprintf("This url %s is not supported.", r.URL.Path), false))
+	Respond(w, Message(nil, fmt.Sprintf("This url %s is not supported.", r.URL.Path), false, false))
 }
 
 // HandleURL check it and validations
@@ -37,11 +48,11 @@ func HandleURL(url string) (string, error) {
 	}
 	u, err := parse.Parse(url)
 	if err != nil {
-        return err.Error(), err
+		return err.Error(), err
 	}
 	if u.Scheme == "" {
 		url = fmt.Sprintf("%s%s", "http://", url)
-	} else if ! strings.HasPrefix(u.Scheme, "http") {
+	} else if !strings.HasPrefix(u.Scheme, "http") {
 		msg := "URL schema must be http or https."
 		return msg, errors.New(msg)
 	}
@@ -57,41 +68,125 @@ func URLPreview(w http.ResponseWriter, r *http.Request) {
 	v := r.URL.Query()
 	url, err := HandleURL(v.Get("url"))
 	if err != nil {
-		Respond(w, Message(nil, url, false))
+		Respond(w, Message(nil, url, false, false))
 		return
 	}
-	pool := newPool()
-    conn := pool.Get()
-	defer conn.Close()
-	meta := getStruct(conn, url)
-    if meta == (HTMLMeta{}) {
-		resp, err := http.Get(url)
-		// handle the error if there is one
-		if err != nil {
-			Respond(w, Message(nil, err.Error(),false))
-			return
-		}
-		// do this now so it won't be forgotten
-		defer resp.Body.Close()
-		meta = Extract(resp.Body)
-
-		setStruct(conn, url, meta)
-	} 
+	cache := getCache()
+	stats := requestStatsFrom(r.Context())
+	fetchStart := time.Now()
+	meta, fromCache, err := fetchMeta(r.Context(), cache, url)
+	stats.FetchLatency = time.Since(fetchStart)
+	stats.CacheHit = fromCache
+	if err != nil {
+		Respond(w, Message(nil, err.Error(), false, false))
+		return
+	}
+	if v.Get("embed_images") == "1" {
+		embedThumbnails(url, &meta)
+	}
 	data := map[string]interface{}{"url": url, "data": meta}
-	Respond(w, Message(data, "",true))
+	Respond(w, Message(data, "", true, fromCache))
+}
+
+// cacheEntry is what a Cache stores per URL: the extracted meta, the
+// validators needed to issue a conditional GET on the next refresh, and
+// (for a negative cache entry) the error from the fetch that failed.
+type cacheEntry struct {
+	Meta         HTMLMeta
+	ETag         string
+	LastModified string
+	ExpiresAt    int64 // unix seconds
+	Negative     bool
+	Err          string
+}
+
+// fetchMeta returns the cached HTMLMeta for url if it's still fresh,
+// otherwise revalidates (or fetches from scratch) and refreshes the
+// cache. The second return value reports whether the result came from
+// cache (a warm hit or a 304 revalidation) rather than a full fetch. A
+// prior failed fetch that's still within its negative-cache window is
+// replayed as an error without hitting the network again.
+func fetchMeta(ctx context.Context, cache Cache, url string) (HTMLMeta, bool, error) {
+	entry, hit, err := cache.Get(url)
+	if err != nil {
+		hit = false
+	}
+	if hit && entry.Negative {
+		return HTMLMeta{}, true, errors.New(entry.Err)
+	}
+	if hit && entry.ExpiresAt > time.Now().Unix() {
+		return entry.Meta, true, nil
+	}
+
+	etag, lastModified := "", ""
+	if hit {
+		etag, lastModified = entry.ETag, entry.LastModified
+	}
+
+	resp, err := fetchURL(ctx, url, etag, lastModified)
+	if err != nil {
+		cache.SetNegative(url, err, negativeCacheTTL)
+		return HTMLMeta{}, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && hit {
+		ttl := ttlFor(resp.Header)
+		entry.ExpiresAt = time.Now().Unix() + int64(ttl.Seconds())
+		cache.Set(url, entry, ttl+revalidationWindow)
+		return entry.Meta, true, nil
+	}
+
+	meta := Extract(io.LimitReader(resp.Body, maxBodyBytes), url)
+	ttl := ttlFor(resp.Header)
+	cache.Set(url, cacheEntry{
+		Meta:         meta,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ExpiresAt:    time.Now().Unix() + int64(ttl.Seconds()),
+	}, ttl+revalidationWindow)
+	return meta, false, nil
+}
+
+// ttlFor picks the cache lifetime for a response: the origin's
+// Cache-Control max-age if it sent a positive one, otherwise
+// defaultCacheTTL. A max-age=0 response is cached for defaultCacheTTL,
+// not revalidated on every request.
+func ttlFor(h http.Header) time.Duration {
+	if age := maxAgeSeconds(h); age > 0 {
+		return time.Duration(age) * time.Second
+	}
+	return defaultCacheTTL
 }
 
 // Message function structure response
-func Message(data interface{}, message string, status bool) (map[string]interface{}) {
+func Message(data interface{}, message string, status bool, fromCache bool) map[string]interface{} {
 	now := time.Now()
-	return map[string]interface{} {"data": data,"status" : status, "message" : message, "current_time": now.Unix()}
+	return map[string]interface{}{"data": data, "status": status, "message": message, "current_time": now.Unix(), "cache_hit": fromCache}
 }
 
 // Respond function send response as json
-func Respond(w http.ResponseWriter, data map[string] interface{})  {
+func Respond(w http.ResponseWriter, data map[string]interface{}) {
+	RespondStatus(w, http.StatusOK, data)
+}
+
+// RespondStatus sends data as JSON with the given HTTP status code, for
+// responses (auth failures, rate limiting) that can't use the default 200.
+func RespondStatus(w http.ResponseWriter, status int, data map[string]interface{}) {
 	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
 }
+
+// OEmbed holds the fields we care about from a page's oEmbed response.
+type OEmbed struct {
+	Title        string
+	AuthorName   string
+	ThumbnailURL string
+	HTML         string
+	ProviderName string
+}
+
 // HTMLMeta data to response
 type HTMLMeta struct {
 	Title       string
@@ -99,21 +194,49 @@ type HTMLMeta struct {
 	Image       string
 	SiteName    string
 	Icon        string
+	OEmbed      *OEmbed `json:",omitempty"`
+	IconData    string  `json:",omitempty"`
+	ImageData   string  `json:",omitempty"`
 }
 
+// jsonLD is the subset of schema.org fields we pull out of
+// application/ld+json blocks. Publisher can be a plain string or an
+// object with a "name" field, so it's decoded separately.
+type jsonLD struct {
+	Name        string          `json:"name"`
+	Headline    string          `json:"headline"`
+	Description string          `json:"description"`
+	Image       json.RawMessage `json:"image"`
+	Publisher   json.RawMessage `json:"publisher"`
+}
 
-// Extract html meta tags
-func Extract(resp io.Reader) (hm HTMLMeta) {
+// Extract html meta tags. pageURL is used to resolve relative oEmbed
+// links and is the URL the page was originally fetched from.
+func Extract(resp io.Reader, pageURL string) (hm HTMLMeta) {
 	z := html.NewTokenizer(resp)
 
+	var twitter HTMLMeta
+	var ld HTMLMeta
+	var fallbackTitle, metaDescription string
+	var oEmbedHref string
+
 	for {
 		tt := z.Next()
 		switch tt {
 		case html.ErrorToken:
+			var oe *OEmbed
+			if oEmbedHref != "" {
+				oe = fetchOEmbedFunc(pageURL, oEmbedHref)
+			}
+			applyFallbacks(&hm, twitter, ld, oe, fallbackTitle, metaDescription)
+			if oe != nil {
+				hm.OEmbed = oe
+			}
 			return
 		case html.StartTagToken, html.SelfClosingTagToken:
 			t := z.Token()
-			if t.Data == "meta" {
+			switch t.Data {
+			case "meta":
 				title, ok := extractMetaProperty(t, "og:title")
 				if ok {
 					hm.Title = title
@@ -133,17 +256,87 @@ func Extract(resp io.Reader) (hm HTMLMeta) {
 				if ok {
 					hm.SiteName = siteName
 				}
-			}
-			if t.Data == "link" {
+
+				if v, ok := extractMetaName(t, "twitter:title"); ok {
+					twitter.Title = v
+				}
+				if v, ok := extractMetaName(t, "twitter:description"); ok {
+					twitter.Description = v
+				}
+				if v, ok := extractMetaName(t, "twitter:image"); ok {
+					twitter.Image = v
+				}
+				if v, ok := extractMetaName(t, "description"); ok {
+					metaDescription = v
+				}
+			case "link":
 				icon, ok := extractIcon(t, "shortcut icon")
 				if ok {
 					hm.Icon = icon
 				}
+				if href, ok := extractOEmbedLink(t); ok {
+					oEmbedHref = href
+				}
+			case "title":
+				if z.Next() == html.TextToken {
+					fallbackTitle = strings.TrimSpace(z.Token().Data)
+				}
+			case "script":
+				if isJSONLD(t) && z.Next() == html.TextToken {
+					if parsed, ok := parseJSONLD(z.Token().Data); ok {
+						ld = parsed
+					}
+				}
 			}
 		}
 	}
 }
 
+// applyFallbacks fills any still-empty fields on hm following the
+// priority OpenGraph -> Twitter -> JSON-LD -> oEmbed -> HTML defaults.
+// oe may be nil if the page had no oEmbed link or the fetch failed.
+func applyFallbacks(hm *HTMLMeta, twitter, ld HTMLMeta, oe *OEmbed, fallbackTitle, metaDescription string) {
+	if hm.Title == "" {
+		hm.Title = twitter.Title
+	}
+	if hm.Title == "" {
+		hm.Title = ld.Title
+	}
+	if hm.Title == "" && oe != nil {
+		hm.Title = oe.Title
+	}
+	if hm.Title == "" {
+		hm.Title = fallbackTitle
+	}
+
+	if hm.Description == "" {
+		hm.Description = twitter.Description
+	}
+	if hm.Description == "" {
+		hm.Description = ld.Description
+	}
+	if hm.Description == "" {
+		hm.Description = metaDescription
+	}
+
+	if hm.Image == "" {
+		hm.Image = twitter.Image
+	}
+	if hm.Image == "" {
+		hm.Image = ld.Image
+	}
+	if hm.Image == "" && oe != nil {
+		hm.Image = oe.ThumbnailURL
+	}
+
+	if hm.SiteName == "" {
+		hm.SiteName = ld.SiteName
+	}
+	if hm.SiteName == "" && oe != nil {
+		hm.SiteName = oe.ProviderName
+	}
+}
+
 func extractMetaProperty(t html.Token, prop string) (content string, ok bool) {
 	for _, attr := range t.Attr {
 		if attr.Key == "property" && attr.Val == prop {
@@ -158,6 +351,21 @@ func extractMetaProperty(t html.Token, prop string) (content string, ok bool) {
 	return
 }
 
+// extractMetaName reads a <meta name="..." content="..."> tag, used by
+// Twitter Cards and the plain HTML description fallback.
+func extractMetaName(t html.Token, name string) (content string, ok bool) {
+	for _, attr := range t.Attr {
+		if attr.Key == "name" && attr.Val == name {
+			ok = true
+		}
+
+		if attr.Key == "content" {
+			content = attr.Val
+		}
+	}
+
+	return
+}
 
 func extractIcon(t html.Token, prop string) (content string, ok bool) {
 	for _, attr := range t.Attr {
@@ -173,56 +381,121 @@ func extractIcon(t html.Token, prop string) (content string, ok bool) {
 	return
 }
 
-func newPool() *redis.Pool {
-	return &redis.Pool{
-		// Maximum number of idle connections in the pool.
-		MaxIdle: 80,
-		// max number of connections
-		MaxActive: 12000,
-		// Dial is an application supplied function for creating and
-		// configuring a connection.
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", ":6379")
-			if err != nil {
-				panic(err.Error())
-			}
-			return c, err
-		},
+// extractOEmbedLink reads <link rel="alternate" type="application/json+oembed" href="...">.
+func extractOEmbedLink(t html.Token) (href string, ok bool) {
+	isAlternate := false
+	isOEmbed := false
+	for _, attr := range t.Attr {
+		switch attr.Key {
+		case "rel":
+			isAlternate = attr.Val == "alternate"
+		case "type":
+			isOEmbed = attr.Val == "application/json+oembed"
+		case "href":
+			href = attr.Val
+		}
 	}
+	ok = isAlternate && isOEmbed && href != ""
+	return
 }
 
-func setStruct(c redis.Conn, key string, data interface{}) error {
+// isJSONLD reports whether a <script> tag is a JSON-LD block.
+func isJSONLD(t html.Token) bool {
+	for _, attr := range t.Attr {
+		if attr.Key == "type" && attr.Val == "application/ld+json" {
+			return true
+		}
+	}
+	return false
+}
 
-	const objectPrefix string = "url_preview:"
+// parseJSONLD decodes a JSON-LD payload into the HTMLMeta fields it maps to.
+func parseJSONLD(raw string) (hm HTMLMeta, ok bool) {
+	var data jsonLD
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		return hm, false
+	}
 
-	// serialize User object to JSON
-	json, err := json.Marshal(data)
-	if err != nil {
-		return err
+	hm.Title = data.Headline
+	if hm.Title == "" {
+		hm.Title = data.Name
+	}
+	hm.Description = data.Description
+
+	if len(data.Image) > 0 {
+		var s string
+		if err := json.Unmarshal(data.Image, &s); err == nil {
+			hm.Image = s
+		} else {
+			var arr []string
+			if err := json.Unmarshal(data.Image, &arr); err == nil && len(arr) > 0 {
+				hm.Image = arr[0]
+			}
+		}
 	}
 
-	// SET object
-	_, err = c.Do("SET", objectPrefix+key, json)
-	if err != nil {
-		return err
+	if len(data.Publisher) > 0 {
+		var s string
+		if err := json.Unmarshal(data.Publisher, &s); err == nil {
+			hm.SiteName = s
+		} else {
+			var obj struct {
+				Name string `json:"name"`
+			}
+			if err := json.Unmarshal(data.Publisher, &obj); err == nil {
+				hm.SiteName = obj.Name
+			}
+		}
 	}
 
-	return nil
+	return hm, true
 }
 
-func getStruct(c redis.Conn, key string) interface{} {
-
-	const objectPrefix string = "url_preview:"
-	data := HTMLMeta{}
+// fetchOEmbedFunc is fetchOEmbed by default; Extract calls through this
+// var so tests can stub the oEmbed fetch without going over the network
+// (fetchOEmbed's SSRF guard refuses loopback addresses, which rules out
+// pointing it at an httptest.Server).
+var fetchOEmbedFunc = fetchOEmbed
 
-	s, err := redis.String(c.Do("GET", objectPrefix+key))
-	if err == redis.ErrNil {
-		return data
-	} else if err != nil {
-		return err
+// fetchOEmbed resolves href against pageURL and follows it, returning the
+// subset of the oEmbed response we surface on HTMLMeta.
+func fetchOEmbed(pageURL, href string) *OEmbed {
+	base, err := parse.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+	ref, err := parse.Parse(href)
+	if err != nil {
+		return nil
 	}
-	err = json.Unmarshal([]byte(s), &data)
+	endpoint := base.ResolveReference(ref).String()
 
-	return data
+	// oEmbedHref comes straight out of page HTML we don't control, so this
+	// must go through the same SSRF-hardened transport as any other
+	// fetch, just with a shorter timeout since it's a best-effort extra.
+	client := http.Client{Timeout: oEmbedTimeout, Transport: httpClient.Transport, CheckRedirect: httpClient.CheckRedirect}
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Title        string `json:"title"`
+		AuthorName   string `json:"author_name"`
+		ThumbnailURL string `json:"thumbnail_url"`
+		HTML         string `json:"html"`
+		ProviderName string `json:"provider_name"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxBodyBytes)).Decode(&body); err != nil {
+		return nil
+	}
 
-}
\ No newline at end of file
+	return &OEmbed{
+		Title:        body.Title,
+		AuthorName:   body.AuthorName,
+		ThumbnailURL: body.ThumbnailURL,
+		HTML:         body.HTML,
+		ProviderName: body.ProviderName,
+	}
+}
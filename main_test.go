@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyFallbacksPriority(t *testing.T) {
+	tests := []struct {
+		name            string
+		hm              HTMLMeta
+		twitter         HTMLMeta
+		ld              HTMLMeta
+		oe              *OEmbed
+		fallbackTitle   string
+		metaDescription string
+		want            HTMLMeta
+	}{
+		{
+			name:            "og wins over everything",
+			hm:              HTMLMeta{Title: "OG Title", Description: "OG Desc", Image: "og.png"},
+			twitter:         HTMLMeta{Title: "TW Title", Description: "TW Desc", Image: "tw.png"},
+			ld:              HTMLMeta{Title: "LD Title", Description: "LD Desc", Image: "ld.png", SiteName: "LD Site"},
+			oe:              &OEmbed{Title: "OE Title", ThumbnailURL: "oe.png", ProviderName: "OE Site"},
+			fallbackTitle:   "HTML Title",
+			metaDescription: "HTML Desc",
+			want:            HTMLMeta{Title: "OG Title", Description: "OG Desc", Image: "og.png", SiteName: "LD Site"},
+		},
+		{
+			name:            "twitter wins over json-ld, oembed, and html",
+			hm:              HTMLMeta{},
+			twitter:         HTMLMeta{Title: "TW Title", Description: "TW Desc", Image: "tw.png"},
+			ld:              HTMLMeta{Title: "LD Title", Description: "LD Desc", Image: "ld.png"},
+			oe:              &OEmbed{Title: "OE Title", ThumbnailURL: "oe.png"},
+			fallbackTitle:   "HTML Title",
+			metaDescription: "HTML Desc",
+			want:            HTMLMeta{Title: "TW Title", Description: "TW Desc", Image: "tw.png"},
+		},
+		{
+			name:            "json-ld wins over oembed and html defaults",
+			hm:              HTMLMeta{},
+			twitter:         HTMLMeta{},
+			ld:              HTMLMeta{Title: "LD Title", Description: "LD Desc", Image: "ld.png", SiteName: "LD Site"},
+			oe:              &OEmbed{Title: "OE Title", ThumbnailURL: "oe.png", ProviderName: "OE Site"},
+			fallbackTitle:   "HTML Title",
+			metaDescription: "HTML Desc",
+			want:            HTMLMeta{Title: "LD Title", Description: "LD Desc", Image: "ld.png", SiteName: "LD Site"},
+		},
+		{
+			name:            "oembed wins over html defaults",
+			hm:              HTMLMeta{},
+			twitter:         HTMLMeta{},
+			ld:              HTMLMeta{},
+			oe:              &OEmbed{Title: "OE Title", ThumbnailURL: "oe.png", ProviderName: "OE Site"},
+			fallbackTitle:   "HTML Title",
+			metaDescription: "HTML Desc",
+			// Description has no oEmbed field, so it still falls through to the HTML meta tag.
+			want: HTMLMeta{Title: "OE Title", Description: "HTML Desc", Image: "oe.png", SiteName: "OE Site"},
+		},
+		{
+			name:            "html defaults used only when nothing else has a value",
+			hm:              HTMLMeta{},
+			twitter:         HTMLMeta{},
+			ld:              HTMLMeta{},
+			oe:              nil,
+			fallbackTitle:   "HTML Title",
+			metaDescription: "HTML Desc",
+			// Image and SiteName have no HTML-tag fallback, so they stay empty.
+			want: HTMLMeta{Title: "HTML Title", Description: "HTML Desc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hm := tt.hm
+			applyFallbacks(&hm, tt.twitter, tt.ld, tt.oe, tt.fallbackTitle, tt.metaDescription)
+			if hm.Title != tt.want.Title {
+				t.Errorf("Title = %q, want %q", hm.Title, tt.want.Title)
+			}
+			if hm.Description != tt.want.Description {
+				t.Errorf("Description = %q, want %q", hm.Description, tt.want.Description)
+			}
+			if hm.Image != tt.want.Image {
+				t.Errorf("Image = %q, want %q", hm.Image, tt.want.Image)
+			}
+			if hm.SiteName != tt.want.SiteName {
+				t.Errorf("SiteName = %q, want %q", hm.SiteName, tt.want.SiteName)
+			}
+		})
+	}
+}
+
+// TestExtractOEmbedOutranksHTMLDefaults guards against the oEmbed merge
+// running after (and so losing to) the bare HTML <title>/<meta
+// name="description"> fallbacks, which let the HTML defaults silently
+// win on virtually every real page.
+func TestExtractOEmbedOutranksHTMLDefaults(t *testing.T) {
+	orig := fetchOEmbedFunc
+	defer func() { fetchOEmbedFunc = orig }()
+	fetchOEmbedFunc = func(pageURL, href string) *OEmbed {
+		return &OEmbed{Title: "OEmbed Title", ThumbnailURL: "oembed.png", ProviderName: "OEmbed Provider"}
+	}
+
+	page := `<html><head>
+<title>HTML Title</title>
+<meta name="description" content="HTML Desc">
+<link rel="alternate" type="application/json+oembed" href="https://example.com/oembed">
+</head><body></body></html>`
+
+	hm := Extract(strings.NewReader(page), "https://example.com/page")
+
+	if hm.Title != "OEmbed Title" {
+		t.Errorf("Title = %q, want oEmbed title to outrank the bare <title> fallback", hm.Title)
+	}
+	if hm.Image != "oembed.png" {
+		t.Errorf("Image = %q, want oEmbed thumbnail", hm.Image)
+	}
+	if hm.SiteName != "OEmbed Provider" {
+		t.Errorf("SiteName = %q, want oEmbed provider name", hm.SiteName)
+	}
+	if hm.Description != "HTML Desc" {
+		t.Errorf("Description = %q, want the HTML meta description (oEmbed carries no description)", hm.Description)
+	}
+	if hm.OEmbed == nil || hm.OEmbed.Title != "OEmbed Title" {
+		t.Errorf("OEmbed = %+v, want the raw oEmbed payload attached", hm.OEmbed)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/subtle"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// defaultRateLimit is the fallback per-client-IP request rate (per
+// second) when PREVIEW_RATE_LIMIT isn't set or isn't a valid number.
+const defaultRateLimit = 5
+
+// defaultRateBurst is the fallback token-bucket burst size when
+// PREVIEW_RATE_BURST isn't set or isn't a valid positive integer.
+const defaultRateBurst = 10
+
+// requestStats carries per-request details that are only known deep
+// inside the handler (upstream fetch latency, cache hit/miss) back out
+// to loggingMiddleware, which doesn't have access to them otherwise.
+type requestStats struct {
+	FetchLatency time.Duration
+	CacheHit     bool
+}
+
+type requestStatsKey struct{}
+
+// withRequestStats returns a context carrying stats, for a handler to
+// fill in as it works.
+func withRequestStats(ctx context.Context, stats *requestStats) context.Context {
+	return context.WithValue(ctx, requestStatsKey{}, stats)
+}
+
+// requestStatsFrom returns the requestStats stashed in ctx by
+// loggingMiddleware, or a throwaway one if there isn't any (e.g. in
+// tests that call a handler directly).
+func requestStatsFrom(ctx context.Context) *requestStats {
+	if s, ok := ctx.Value(requestStatsKey{}).(*requestStats); ok {
+		return s
+	}
+	return &requestStats{}
+}
+
+// statusRecorder wraps http.ResponseWriter to remember the status code
+// written, since the standard interface doesn't expose it afterward.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+// loggingMiddleware logs each request's method, path, upstream fetch
+// latency, cache hit/miss, and response status once the handler
+// underneath it has finished.
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats := &requestStats{}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r.WithContext(withRequestStats(r.Context(), stats)))
+
+		log.Printf("%s %s status=%d cache_hit=%t fetch_latency=%s",
+			r.Method, r.URL.Path, rec.status, stats.CacheHit, stats.FetchLatency)
+	})
+}
+
+// authMiddleware enforces HTTP Basic or bearer-token auth when
+// configured via env, and is a no-op otherwise. PREVIEW_AUTH_TOKEN, if
+// set, requires a matching "Authorization: Bearer <token>" header;
+// otherwise PREVIEW_AUTH_USER/PREVIEW_AUTH_PASS, if both set, require
+// matching HTTP Basic credentials.
+func authMiddleware(next http.Handler) http.Handler {
+	token := os.Getenv("PREVIEW_AUTH_TOKEN")
+	user, pass := os.Getenv("PREVIEW_AUTH_USER"), os.Getenv("PREVIEW_AUTH_PASS")
+	if token == "" && (user == "" || pass == "") {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			if secureEquals(bearerToken(r), token) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		} else if u, p, ok := r.BasicAuth(); ok && secureEquals(u, user) && secureEquals(p, pass) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="urlPreviewGo"`)
+		RespondStatus(w, http.StatusUnauthorized, Message(nil, "Authentication required.", false, false))
+	})
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>"
+// header, or "" if it's missing or a different scheme.
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if !strings.HasPrefix(h, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(h, prefix)
+}
+
+// secureEquals compares two strings in constant time, so a mismatching
+// credential can't be brute-forced via timing.
+func secureEquals(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// maxRateLimiterIPs bounds how many per-IP limiters rateLimiter keeps
+// alive at once, evicting the least-recently-seen IP past it so a
+// process that stays up for a long time under varied traffic doesn't
+// grow its limiter map without bound.
+const maxRateLimiterIPs = 50000
+
+// rateLimiter enforces a per-client-IP token-bucket rate limit, backed
+// by golang.org/x/time/rate, allocating one limiter per IP on first use
+// and evicting the least-recently-seen IP once maxRateLimiterIPs is
+// exceeded.
+type rateLimiter struct {
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	rps   rate.Limit
+	burst int
+}
+
+type rateLimiterItem struct {
+	ip  string
+	lim *rate.Limiter
+}
+
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	return &rateLimiter{ll: list.New(), items: make(map[string]*list.Element), rps: rate.Limit(rps), burst: burst}
+}
+
+func (rl *rateLimiter) allow(ip string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	elem, ok := rl.items[ip]
+	if ok {
+		rl.ll.MoveToFront(elem)
+		return elem.Value.(*rateLimiterItem).lim.Allow()
+	}
+
+	lim := rate.NewLimiter(rl.rps, rl.burst)
+	rl.items[ip] = rl.ll.PushFront(&rateLimiterItem{ip: ip, lim: lim})
+	if rl.ll.Len() > maxRateLimiterIPs {
+		if oldest := rl.ll.Back(); oldest != nil {
+			rl.ll.Remove(oldest)
+			delete(rl.items, oldest.Value.(*rateLimiterItem).ip)
+		}
+	}
+	return lim.Allow()
+}
+
+// rateLimitMiddleware rejects requests over the per-client-IP rate
+// limit with 429, configured by PREVIEW_RATE_LIMIT (requests/sec,
+// default defaultRateLimit) and PREVIEW_RATE_BURST (default
+// defaultRateBurst). Set PREVIEW_RATE_LIMIT to a non-positive value to
+// disable it.
+func rateLimitMiddleware(next http.Handler) http.Handler {
+	if v := os.Getenv("PREVIEW_RATE_LIMIT"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f <= 0 {
+			return next
+		}
+	}
+	rps := envFloat("PREVIEW_RATE_LIMIT", defaultRateLimit)
+	limiter := newRateLimiter(rps, envInt("PREVIEW_RATE_BURST", defaultRateBurst))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.allow(clientIP(r)) {
+			RespondStatus(w, http.StatusTooManyRequests, Message(nil, "Rate limit exceeded.", false, false))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the request's client IP, stripping the port from
+// RemoteAddr.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// envInt reads key from the environment as an integer, falling back to
+// def if it's unset or not a valid positive integer.
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
+// envFloat reads key from the environment as a float64, falling back to
+// def if it's unset or not a valid positive number.
+func envFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 {
+		return def
+	}
+	return f
+}